@@ -3,15 +3,20 @@ package main
 import (
 	"archive/zip"
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 type Config struct {
@@ -19,16 +24,60 @@ type Config struct {
 	IgnoreFile     string
 	MaxDepth       int
 	OutputDir      string
+	Token          string
+	Provider       string
+	Ref            string
+	SparsePaths    []string
+	MaxBytes       int64
+	MaxEntries     int
+	MaxPathDepth   int
+	UseGitignore   bool
 	ignorePatterns []string
+	ignoreRules    []ignoreRule
+}
+
+// RepoJob is one repository to process: its source URL and the optional
+// per-repo overrides a manifest line can supply.
+type RepoJob struct {
+	URL          string
+	Ref          string
+	OutputSubdir string
+}
+
+// stringSliceFlag collects every occurrence of a repeatable flag into a
+// slice, e.g. -path a -path b -> []string{"a", "b"}.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 func main() {
 	var config Config
+	var sparsePaths stringSliceFlag
+	var repoURLs stringSliceFlag
+	var manifestFile string
+	var jobCount int
 
-	flag.StringVar(&config.GitRepoURL, "g", "", "Git repository URL")
+	flag.Var(&repoURLs, "g", "Git repository URL (repeatable for multiple repos)")
 	flag.StringVar(&config.IgnoreFile, "i", "", "Ignore file with patterns (one pattern per line)")
 	flag.IntVar(&config.MaxDepth, "d", -1, "Maximum depth of folders to copy (-1 for unlimited)")
 	flag.StringVar(&config.OutputDir, "o", ".", "Output directory (default: current directory)")
+	flag.StringVar(&config.Token, "token", "", "Access token for private repositories (falls back to GIT_TOKEN/GITHUB_TOKEN/GITLAB_TOKEN)")
+	flag.StringVar(&config.Provider, "provider", "", "Git hosting provider: github, gitlab, or bitbucket (default: detected from URL)")
+	flag.StringVar(&config.Ref, "ref", "", "Branch, tag, or commit SHA to copy (default: discovered from the provider API, falling back to main/master)")
+	flag.Var(&sparsePaths, "path", "Directory subtree to include (repeatable); when set, only these subtrees are checked out (git) or extracted from the full ZIP archive (HTTP)")
+	flag.StringVar(&manifestFile, "manifest", "", "File listing repos to process, one 'url[,ref,output-subdir]' per line")
+	flag.IntVar(&jobCount, "jobs", 1, "Number of repositories to process concurrently")
+	flag.Int64Var(&config.MaxBytes, "max-bytes", 500*1024*1024, "Maximum size in bytes for both the downloaded ZIP archive and its total declared decompressed contents, for HTTP downloads (0 for unlimited)")
+	flag.IntVar(&config.MaxEntries, "max-entries", 50000, "Maximum number of ZIP entries to process for HTTP downloads (0 for unlimited)")
+	flag.IntVar(&config.MaxPathDepth, "max-path-depth", -1, "Maximum directory depth to extract from a ZIP download (-1 for unlimited)")
+	flag.BoolVar(&config.UseGitignore, "use-gitignore", false, "Also ignore directories matched by the cloned repo's .gitignore")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
@@ -41,40 +90,155 @@ func main() {
 
 	flag.Parse()
 
-	if config.GitRepoURL == "" {
-		fmt.Fprintf(os.Stderr, "Error: Git repository URL is required (-g flag)\n")
+	jobs, err := buildJobs([]string(repoURLs), manifestFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(jobs) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: at least one git repository URL is required (-g flag or -manifest)\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if err := run(config); err != nil {
+	config.Token = resolveToken(config.Token)
+	config.SparsePaths = []string(sparsePaths)
+
+	if config.IgnoreFile != "" {
+		patterns, err := loadIgnorePatterns(config.IgnoreFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to load ignore patterns: %v\n", err)
+			os.Exit(1)
+		}
+		config.ignorePatterns = patterns
+	}
+
+	if err := runAll(context.Background(), jobs, config, jobCount); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run(config Config) error {
+// buildJobs combines repeated -g URLs with manifest file entries into the
+// full list of repos to process.
+func buildJobs(repoURLs []string, manifestFile string) ([]RepoJob, error) {
+	var jobs []RepoJob
+	for _, repoURL := range repoURLs {
+		jobs = append(jobs, RepoJob{URL: repoURL})
+	}
 
-	if config.IgnoreFile != "" {
-		patterns, err := loadIgnorePatterns(config.IgnoreFile)
+	if manifestFile != "" {
+		manifestJobs, err := parseManifest(manifestFile)
 		if err != nil {
-			return fmt.Errorf("failed to load ignore patterns: %w", err)
+			return nil, fmt.Errorf("failed to load manifest: %w", err)
 		}
-		config.ignorePatterns = patterns
+		jobs = append(jobs, manifestJobs...)
+	}
+
+	return jobs, nil
+}
+
+// parseManifest reads a file listing one repo per line as
+// "url[,ref[,output-subdir]]", skipping blank lines and "#" comments.
+func parseManifest(filename string) ([]RepoJob, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var jobs []RepoJob
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		job := RepoJob{URL: strings.TrimSpace(fields[0])}
+		if len(fields) > 1 {
+			job.Ref = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 {
+			job.OutputSubdir = strings.TrimSpace(fields[2])
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, scanner.Err()
+}
+
+// runAll processes every job with up to `workers` repos in flight at
+// once, aggregating all per-repo failures into a single joined error.
+func runAll(ctx context.Context, jobs []RepoJob, base Config, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sem := make(chan struct{}, workers)
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+
+	for i, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, job RepoJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			cfg := base
+			cfg.GitRepoURL = job.URL
+			if job.Ref != "" {
+				cfg.Ref = job.Ref
+			}
+			if job.OutputSubdir != "" {
+				cfg.OutputDir = filepath.Join(base.OutputDir, job.OutputSubdir)
+			}
+
+			prefix := fmt.Sprintf("[%d/%d %s]", i+1, len(jobs), getRepoName(job.URL))
+			if err := ProcessRepo(ctx, cfg, prefix); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", job.URL, err)
+			}
+		}(i, job)
 	}
 
-	tempDir, err := os.MkdirTemp("", "git-folder-copier-*")
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// ProcessRepo downloads a single repository and copies its folder
+// structure per config. Its own start, copy and success lines are
+// prefixed with prefix; the lines printed by the download and copy steps
+// it calls (and git's own output) carry no prefix.
+// It is the reusable core of the tool's download+extract pipeline, safe
+// to call concurrently for different repos since each call gets its own
+// uniquely-suffixed temp directory.
+func ProcessRepo(ctx context.Context, config Config, prefix string) error {
+	tempDir, err := os.MkdirTemp("", "git-folder-copier-"+getRepoName(config.GitRepoURL)+"-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
-	fmt.Printf("Downloading repository: %s\n", config.GitRepoURL)
+	fmt.Printf("%s Downloading repository: %s\n", prefix, config.GitRepoURL)
 	repoDir := filepath.Join(tempDir, "repo")
-	if err := downloadRepo(config.GitRepoURL, repoDir); err != nil {
+	if err := downloadRepo(ctx, config, repoDir); err != nil {
 		return fmt.Errorf("failed to download repository: %w", err)
 	}
 
+	ignorePatterns := config.ignorePatterns
+	if config.UseGitignore {
+		gitignorePatterns, err := loadIgnorePatterns(filepath.Join(repoDir, ".gitignore"))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to load .gitignore: %w", err)
+		}
+		ignorePatterns = append(gitignorePatterns, ignorePatterns...)
+	}
+	config.ignoreRules = compileIgnoreRules(ignorePatterns)
+
 	repoName := getRepoName(config.GitRepoURL)
 	outputPath := config.OutputDir
 
@@ -82,12 +246,12 @@ func run(config Config) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	fmt.Printf("Copying folder structure to: %s\n", outputPath)
-	if err := copyFolderStructure(repoDir, outputPath, config, 0); err != nil {
+	fmt.Printf("%s Copying folder structure to: %s\n", prefix, outputPath)
+	if err := copyFolderStructure(repoDir, outputPath, config, 0, ""); err != nil {
 		return fmt.Errorf("failed to copy folder structure: %w", err)
 	}
 
-	fmt.Printf("Successfully copied folder structure from %s to: %s\n", repoName, outputPath)
+	fmt.Printf("%s Successfully copied folder structure from %s to: %s\n", prefix, repoName, outputPath)
 	return nil
 }
 
@@ -111,15 +275,240 @@ func loadIgnorePatterns(filename string) ([]string, error) {
 	return patterns, scanner.Err()
 }
 
-func downloadRepo(repoURL, destDir string) error {
+// Provider abstracts over the per-host conventions needed to fetch a
+// repository's contents: where its ZIP archive lives, how to
+// authenticate requests against it, and how to discover its default
+// branch when no ref is specified.
+type Provider interface {
+	ArchiveURL(repoURL, ref string) (string, error)
+	AuthHeaders(token string) map[string]string
+	CloneCredentials(token string) (username, password string)
+	DiscoverDefaultBranch(ctx context.Context, repoURL, token string) (string, error)
+}
+
+type GitHubProvider struct{}
+
+func (p GitHubProvider) ArchiveURL(repoURL, ref string) (string, error) {
+	return strings.TrimSuffix(repoURL, ".git") + "/archive/" + ref + ".zip", nil
+}
+
+func (p GitHubProvider) AuthHeaders(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "token " + token}
+}
+
+// CloneCredentials reports GitHub's convention for personal access
+// tokens: the token as username, with an empty password.
+func (p GitHubProvider) CloneCredentials(token string) (username, password string) {
+	return token, ""
+}
+
+func (p GitHubProvider) DiscoverDefaultBranch(ctx context.Context, repoURL, token string) (string, error) {
+	owner, repo, err := parseOwnerRepo(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	apiURL := "https://api.github.com/repos/" + owner + "/" + repo
+	var body struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := fetchJSON(ctx, apiURL, p.AuthHeaders(token), &body); err != nil {
+		return "", err
+	}
+	if body.DefaultBranch == "" {
+		return "", fmt.Errorf("github API returned no default branch for %s/%s", owner, repo)
+	}
+	return body.DefaultBranch, nil
+}
+
+type GitLabProvider struct{}
+
+func (p GitLabProvider) ArchiveURL(repoURL, ref string) (string, error) {
+	return strings.TrimSuffix(repoURL, ".git") + "/-/archive/" + ref + "/archive.zip", nil
+}
+
+func (p GitLabProvider) AuthHeaders(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	return map[string]string{"PRIVATE-TOKEN": token}
+}
+
+// CloneCredentials reports GitLab's "oauth2" username convention for
+// personal/project access tokens.
+func (p GitLabProvider) CloneCredentials(token string) (username, password string) {
+	return "oauth2", token
+}
+
+func (p GitLabProvider) DiscoverDefaultBranch(ctx context.Context, repoURL, token string) (string, error) {
+	owner, repo, err := parseOwnerRepo(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	projectPath := url.QueryEscape(owner + "/" + repo)
+	apiURL := "https://gitlab.com/api/v4/projects/" + projectPath
+	var body struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := fetchJSON(ctx, apiURL, p.AuthHeaders(token), &body); err != nil {
+		return "", err
+	}
+	if body.DefaultBranch == "" {
+		return "", fmt.Errorf("gitlab API returned no default branch for %s/%s", owner, repo)
+	}
+	return body.DefaultBranch, nil
+}
+
+type BitbucketProvider struct{}
+
+func (p BitbucketProvider) ArchiveURL(repoURL, ref string) (string, error) {
+	return strings.TrimSuffix(repoURL, ".git") + "/get/" + ref + ".zip", nil
+}
+
+func (p BitbucketProvider) AuthHeaders(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// CloneCredentials reports Bitbucket's "x-token-auth" username
+// convention for repository access tokens.
+func (p BitbucketProvider) CloneCredentials(token string) (username, password string) {
+	return "x-token-auth", token
+}
+
+func (p BitbucketProvider) DiscoverDefaultBranch(ctx context.Context, repoURL, token string) (string, error) {
+	owner, repo, err := parseOwnerRepo(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	apiURL := "https://api.bitbucket.org/2.0/repositories/" + owner + "/" + repo
+	var body struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	if err := fetchJSON(ctx, apiURL, p.AuthHeaders(token), &body); err != nil {
+		return "", err
+	}
+	if body.MainBranch.Name == "" {
+		return "", fmt.Errorf("bitbucket API returned no default branch for %s/%s", owner, repo)
+	}
+	return body.MainBranch.Name, nil
+}
+
+// parseOwnerRepo extracts the "owner/repo" portion from a repository URL,
+// e.g. https://github.com/owner/repo(.git) -> ("owner", "repo").
+func parseOwnerRepo(repoURL string) (string, string, error) {
+	cleanURL := strings.TrimSuffix(strings.TrimSuffix(repoURL, ".git"), "/")
+	parts := strings.Split(cleanURL, "/")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("could not parse owner/repo from URL: %s", repoURL)
+	}
+	return parts[len(parts)-2], parts[len(parts)-1], nil
+}
+
+// fetchJSON GETs apiURL with the given headers and decodes the JSON
+// response body into out.
+func fetchJSON(ctx context.Context, apiURL string, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	for header, value := range headers {
+		req.Header.Set(header, value)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API request to %s failed: HTTP %d", apiURL, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// commitSHAPattern matches a full or abbreviated git commit SHA.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// resolveRefCandidates returns the ordered list of refs to try for
+// repoURL. An explicit ref is used as-is; otherwise the provider's API is
+// queried for the default branch, falling back to trying "main" then
+// "master" if that query fails.
+func resolveRefCandidates(ctx context.Context, repoURL, explicitRef, token string, provider Provider) []string {
+	if explicitRef != "" {
+		return []string{explicitRef}
+	}
+
+	if branch, err := provider.DiscoverDefaultBranch(ctx, repoURL, token); err == nil {
+		return []string{branch}
+	}
+
+	return []string{"main", "master"}
+}
+
+// detectProvider picks a Provider for repoURL, honoring an explicit
+// override before falling back to hostname sniffing.
+func detectProvider(repoURL, override string) Provider {
+	switch strings.ToLower(override) {
+	case "github":
+		return GitHubProvider{}
+	case "gitlab":
+		return GitLabProvider{}
+	case "bitbucket":
+		return BitbucketProvider{}
+	}
+
+	switch {
+	case strings.Contains(repoURL, "gitlab.com"):
+		return GitLabProvider{}
+	case strings.Contains(repoURL, "bitbucket.org"):
+		return BitbucketProvider{}
+	default:
+		return GitHubProvider{}
+	}
+}
+
+// resolveToken returns the explicit -token flag value if set, otherwise
+// falls back to GIT_TOKEN, GITHUB_TOKEN, then GITLAB_TOKEN in the
+// environment.
+func resolveToken(flagToken string) string {
+	if flagToken != "" {
+		return flagToken
+	}
+
+	for _, envVar := range []string{"GIT_TOKEN", "GITHUB_TOKEN", "GITLAB_TOKEN"} {
+		if token := os.Getenv(envVar); token != "" {
+			return token
+		}
+	}
+
+	return ""
+}
+
+func downloadRepo(ctx context.Context, config Config, destDir string) error {
+	provider := detectProvider(config.GitRepoURL, config.Provider)
+	candidates := resolveRefCandidates(ctx, config.GitRepoURL, config.Ref, config.Token, provider)
 
 	if isGitInstalled() {
 		fmt.Println("Using git clone...")
-		return cloneRepo(repoURL, destDir)
+		return cloneRepo(ctx, config.GitRepoURL, config.Token, candidates, provider, config.SparsePaths, destDir)
 	}
 
 	fmt.Println("Git not found, using HTTP download...")
-	return downloadRepoHTTP(repoURL, destDir)
+	limits := ExtractLimits{MaxBytes: config.MaxBytes, MaxEntries: config.MaxEntries, MaxPathDepth: config.MaxPathDepth}
+	return downloadRepoHTTP(ctx, config.GitRepoURL, config.Token, candidates, provider, config.SparsePaths, limits, destDir)
 }
 
 func isGitInstalled() bool {
@@ -127,76 +516,234 @@ func isGitInstalled() bool {
 	return err == nil
 }
 
-func cloneRepo(repoURL, destDir string) error {
-	cmd := exec.Command("git", "clone", "--depth", "1", repoURL, destDir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// cloneRepo tries each ref in candidates until one clones successfully,
+// returning an error listing every ref that was tried. A ref that looks
+// like a commit SHA requires a shallow clone followed by a checkout,
+// since git clone --branch only accepts branch and tag names. When
+// sparsePaths is non-empty, only those directory subtrees are checked
+// out. repoURL is cloned as-is, with no credentials embedded in it or in
+// argv: when token is set, a short-lived GIT_ASKPASS script supplies
+// provider's credential convention instead, so the token never appears
+// in process listings or gets written into destDir/.git/config.
+func cloneRepo(ctx context.Context, repoURL, token string, candidates []string, provider Provider, sparsePaths []string, destDir string) error {
+	cloneEnv := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	if token != "" && strings.HasPrefix(repoURL, "https://") {
+		username, password := provider.CloneCredentials(token)
+		askpassPath, cleanup, err := writeAskpassScript(username, password)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		cloneEnv = append(cloneEnv,
+			"GIT_ASKPASS="+askpassPath,
+			"GIT_FOLDER_COPIER_ASKPASS_USERNAME="+username,
+			"GIT_FOLDER_COPIER_ASKPASS_PASSWORD="+password,
+		)
+	} else {
+		cloneEnv = append(cloneEnv, "GIT_ASKPASS=")
+	}
+
+	var errs []error
+	for _, ref := range candidates {
+		if err := os.RemoveAll(destDir); err != nil {
+			return err
+		}
+
+		args := []string{"clone", "--depth", "1"}
+		if len(sparsePaths) > 0 {
+			args = append(args, "--filter=blob:none", "--sparse")
+		}
+		if !commitSHAPattern.MatchString(ref) {
+			args = append(args, "--branch", ref)
+		}
+		args = append(args, repoURL, destDir)
+
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = cloneEnv
+
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("ref %q: %w", ref, err))
+			continue
+		}
+
+		if commitSHAPattern.MatchString(ref) {
+			checkout := exec.CommandContext(ctx, "git", "-C", destDir, "checkout", ref)
+			checkout.Stdout = os.Stdout
+			checkout.Stderr = os.Stderr
+			if err := checkout.Run(); err != nil {
+				errs = append(errs, fmt.Errorf("ref %q: checkout failed: %w", ref, err))
+				continue
+			}
+		}
+
+		if len(sparsePaths) > 0 {
+			sparseArgs := append([]string{"-C", destDir, "sparse-checkout", "set"}, sparsePaths...)
+			sparseCheckout := exec.CommandContext(ctx, "git", sparseArgs...)
+			sparseCheckout.Stdout = os.Stdout
+			sparseCheckout.Stderr = os.Stderr
+			if err := sparseCheckout.Run(); err != nil {
+				errs = append(errs, fmt.Errorf("ref %q: sparse-checkout failed: %w", ref, err))
+				continue
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("failed to clone any of the tried refs %v: %w", candidates, errors.Join(errs...))
+}
+
+// writeAskpassScript creates a short-lived, owner-executable shell script
+// that git can invoke via GIT_ASKPASS to answer its "Username for"/
+// "Password for" prompts. The credentials are passed to the script
+// through its own environment rather than as script arguments or a URL,
+// so they never appear in argv or in a cloned repo's remote config.
+// Callers must invoke the returned cleanup once the clone is done.
+func writeAskpassScript(username, password string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "git-folder-copier-askpass-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create askpass script dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	script := "#!/bin/sh\n" +
+		"case \"$1\" in\n" +
+		"  Username*) printf '%s' \"$GIT_FOLDER_COPIER_ASKPASS_USERNAME\" ;;\n" +
+		"  *) printf '%s' \"$GIT_FOLDER_COPIER_ASKPASS_PASSWORD\" ;;\n" +
+		"esac\n"
+
+	scriptPath := filepath.Join(dir, "askpass.sh")
+	if err := os.WriteFile(scriptPath, []byte(script), 0700); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write askpass script: %w", err)
+	}
+
+	return scriptPath, cleanup, nil
+}
+
+// downloadRepoHTTP tries each ref in candidates until its archive
+// downloads successfully, returning an error listing every ref tried.
+func downloadRepoHTTP(ctx context.Context, repoURL, token string, candidates []string, provider Provider, sparsePaths []string, limits ExtractLimits, destDir string) error {
+	var errs []error
+	for _, ref := range candidates {
+		zipPath, err := downloadZipForRef(ctx, repoURL, token, ref, provider, limits.MaxBytes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ref %q: %w", ref, err))
+			continue
+		}
+		defer os.Remove(zipPath)
+
+		return extractZipDirectoriesOnly(zipPath, destDir, sparsePaths, limits)
+	}
+
+	return fmt.Errorf("failed to download any of the tried refs %v: %w", candidates, errors.Join(errs...))
 }
 
-func downloadRepoHTTP(repoURL, destDir string) error {
-	zipURL, err := convertToZipURL(repoURL)
+// downloadZipForRef streams the archive to a temp file, aborting once
+// more than maxBytes have been written to disk (maxBytes <= 0 means
+// unlimited). This bounds the downloaded, still-compressed archive size;
+// extractZipDirectoriesOnly separately bounds the archive's declared
+// decompressed size before extracting anything. The temp file itself
+// can't be avoided: archive/zip.Reader requires an io.ReaderAt to read
+// the central directory at the end of the archive, so the bytes have to
+// land somewhere seekable before they can be parsed at all — there's no
+// true stream-straight-from-the-response-body path with the standard
+// library's zip reader.
+func downloadZipForRef(ctx context.Context, repoURL, token, ref string, provider Provider, maxBytes int64) (string, error) {
+	zipURL, err := provider.ArchiveURL(repoURL, ref)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	fmt.Printf("Downloading ZIP from: %s\n", zipURL)
 
-	resp, err := http.Get(zipURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, zipURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to download repository: %w", err)
+		return "", fmt.Errorf("failed to build download request: %w", err)
+	}
+	for header, value := range provider.AuthHeaders(token) {
+		req.Header.Set(header, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download repository: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download repository: HTTP %d", resp.StatusCode)
+		return "", fmt.Errorf("failed to download repository: HTTP %d", resp.StatusCode)
 	}
 
 	zipFile, err := os.CreateTemp("", "repo-*.zip")
 	if err != nil {
-		return fmt.Errorf("failed to create temp ZIP file: %w", err)
+		return "", fmt.Errorf("failed to create temp ZIP file: %w", err)
 	}
-	defer os.Remove(zipFile.Name())
 	defer zipFile.Close()
 
-	_, err = io.Copy(zipFile, resp.Body)
+	body := io.Reader(resp.Body)
+	if maxBytes > 0 {
+		body = io.LimitReader(resp.Body, maxBytes+1)
+	}
+
+	written, err := io.Copy(zipFile, body)
 	if err != nil {
-		return fmt.Errorf("failed to save ZIP file: %w", err)
+		os.Remove(zipFile.Name())
+		return "", fmt.Errorf("failed to save ZIP file: %w", err)
+	}
+	if maxBytes > 0 && written > maxBytes {
+		os.Remove(zipFile.Name())
+		return "", fmt.Errorf("downloaded archive exceeds max-bytes limit of %d", maxBytes)
 	}
 
-	return extractZipDirectoriesOnly(zipFile.Name(), destDir)
+	return zipFile.Name(), nil
 }
 
-func convertToZipURL(repoURL string) (string, error) {
-
-	cleanURL := strings.TrimSuffix(repoURL, ".git")
-
-	if strings.Contains(cleanURL, "github.com") {
-		return cleanURL + "/archive/refs/heads/main.zip", nil
-	} else if strings.Contains(cleanURL, "gitlab.com") {
-		return cleanURL + "/-/archive/main/archive.zip", nil
-	} else if strings.Contains(cleanURL, "bitbucket.org") {
-		return cleanURL + "/get/main.zip", nil
-	}
-
-	return cleanURL + "/archive/refs/heads/main.zip", nil
+// ExtractLimits bounds how much of a ZIP archive extractZipDirectoriesOnly
+// is willing to process. MaxBytes and MaxEntries treat zero or negative
+// as unlimited. MaxPathDepth treats negative as unlimited and zero as
+// root-only (no directories are extracted); a positive n keeps
+// directories at most n levels below the archive root. This is one level
+// stricter than MaxDepth, where zero still copies the top-level
+// directories.
+type ExtractLimits struct {
+	MaxBytes     int64
+	MaxEntries   int
+	MaxPathDepth int
 }
 
-func extractZipDirectoriesOnly(zipPath, destDir string) error {
+// extractZipDirectoriesOnly recreates the ZIP archive's directory
+// structure under destDir without extracting any file contents. It
+// rejects the archive up front, before creating anything on disk, if
+// the entry count or the entries' declared total decompressed size
+// (a zip-bomb guard that doesn't require actually decompressing them)
+// exceeds limits.
+func extractZipDirectoriesOnly(zipPath, destDir string, sparsePaths []string, limits ExtractLimits) error {
 	reader, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return fmt.Errorf("failed to open ZIP file: %w", err)
 	}
 	defer reader.Close()
 
-	var rootDir string
-	for _, file := range reader.File {
-		if file.FileInfo().IsDir() && strings.Count(file.Name, "/") == 1 {
-			rootDir = file.Name
-			break
+	if limits.MaxEntries > 0 && len(reader.File) > limits.MaxEntries {
+		return fmt.Errorf("archive has %d entries, exceeding max-entries limit of %d", len(reader.File), limits.MaxEntries)
+	}
+
+	if limits.MaxBytes > 0 {
+		var totalUncompressed uint64
+		for _, file := range reader.File {
+			totalUncompressed += file.UncompressedSize64
+		}
+		if totalUncompressed > uint64(limits.MaxBytes) {
+			return fmt.Errorf("archive's total decompressed size %d exceeds max-bytes limit of %d", totalUncompressed, limits.MaxBytes)
 		}
 	}
 
+	rootDir := archiveRootDir(reader.File)
+
 	for _, file := range reader.File {
 		if !strings.HasPrefix(file.Name, rootDir) {
 			continue
@@ -211,10 +758,20 @@ func extractZipDirectoriesOnly(zipPath, destDir string) error {
 			continue
 		}
 
-		destPath := filepath.Join(destDir, relativePath)
+		if limits.MaxPathDepth >= 0 && strings.Count(strings.Trim(relativePath, "/"), "/")+1 > limits.MaxPathDepth {
+			continue
+		}
+
+		if len(sparsePaths) > 0 && !underAnySparsePath(relativePath, sparsePaths) {
+			continue
+		}
 
-		err := os.MkdirAll(destPath, 0755)
+		destPath, err := safeJoin(destDir, relativePath)
 		if err != nil {
+			return fmt.Errorf("refusing to extract %q: %w", file.Name, err)
+		}
+
+		if err := os.MkdirAll(destPath, 0755); err != nil {
 			return fmt.Errorf("failed to create directory: %w", err)
 		}
 	}
@@ -222,6 +779,79 @@ func extractZipDirectoriesOnly(zipPath, destDir string) error {
 	return nil
 }
 
+// driveLetterPattern matches a Windows drive-letter prefix like "C:".
+var driveLetterPattern = regexp.MustCompile(`^[A-Za-z]:`)
+
+// safeJoin joins destDir and relativePath, rejecting any result that
+// would escape destDir (a "zip-slip" entry like "../../etc") or that
+// carries an absolute path or drive letter.
+func safeJoin(destDir, relativePath string) (string, error) {
+	if filepath.IsAbs(relativePath) || driveLetterPattern.MatchString(relativePath) {
+		return "", fmt.Errorf("unsafe path in archive: %s", relativePath)
+	}
+
+	destPath := filepath.Join(destDir, relativePath)
+
+	rel, err := filepath.Rel(destDir, destPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unsafe path in archive: %s", relativePath)
+	}
+
+	return destPath, nil
+}
+
+// archiveRootDir finds the deepest directory shared by every entry name
+// (for a provider archive, its single top-level directory such as
+// "repo-main/") by taking the common prefix across every entry name and
+// trimming back to the last path separator, rather than assuming the
+// first entry is the root. If every entry lies under a nested directory,
+// that nested directory (e.g. "repo-main/src/") is returned.
+func archiveRootDir(files []*zip.File) string {
+	if len(files) == 0 {
+		return ""
+	}
+
+	prefix := files[0].Name
+	for _, file := range files[1:] {
+		prefix = commonPrefix(prefix, file.Name)
+		if prefix == "" {
+			return ""
+		}
+	}
+
+	if idx := strings.LastIndex(prefix, "/"); idx >= 0 {
+		return prefix[:idx+1]
+	}
+	return ""
+}
+
+func commonPrefix(a, b string) string {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+	i := 0
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+// underAnySparsePath reports whether relativePath falls under, or is an
+// ancestor of, one of the requested sparse-checkout subtrees.
+func underAnySparsePath(relativePath string, sparsePaths []string) bool {
+	relativePath = strings.Trim(relativePath, "/")
+	for _, sparsePath := range sparsePaths {
+		sparsePath = strings.Trim(sparsePath, "/")
+		if relativePath == sparsePath ||
+			strings.HasPrefix(relativePath, sparsePath+"/") ||
+			strings.HasPrefix(sparsePath, relativePath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func getRepoName(repoURL string) string {
 
 	parts := strings.Split(repoURL, "/")
@@ -236,7 +866,7 @@ func getRepoName(repoURL string) string {
 	return "repo"
 }
 
-func copyFolderStructure(srcDir, destDir string, config Config, currentDepth int) error {
+func copyFolderStructure(srcDir, destDir string, config Config, currentDepth int, repoRelPath string) error {
 
 	if config.MaxDepth >= 0 && currentDepth > config.MaxDepth {
 		return nil
@@ -257,8 +887,13 @@ func copyFolderStructure(srcDir, destDir string, config Config, currentDepth int
 			continue
 		}
 
-		if shouldIgnoreDirectory(entry.Name(), config.ignorePatterns) {
-			fmt.Printf("Ignoring directory: %s\n", entry.Name())
+		entryRelPath := entry.Name()
+		if repoRelPath != "" {
+			entryRelPath = repoRelPath + "/" + entry.Name()
+		}
+
+		if shouldIgnoreDirectory(entryRelPath, config.ignoreRules) {
+			fmt.Printf("Ignoring directory: %s\n", entryRelPath)
 			continue
 		}
 
@@ -271,7 +906,7 @@ func copyFolderStructure(srcDir, destDir string, config Config, currentDepth int
 
 		fmt.Printf("Created directory: %s\n", destPath)
 
-		if err := copyFolderStructure(srcPath, destPath, config, currentDepth+1); err != nil {
+		if err := copyFolderStructure(srcPath, destPath, config, currentDepth+1, entryRelPath); err != nil {
 			return err
 		}
 	}
@@ -279,24 +914,102 @@ func copyFolderStructure(srcDir, destDir string, config Config, currentDepth int
 	return nil
 }
 
-func shouldIgnoreDirectory(dirName string, patterns []string) bool {
-	for _, pattern := range patterns {
+// ignoreRule is one compiled gitignore-style pattern: a regex matched
+// against a directory's repo-relative path, plus whether it re-includes
+// (negates) rather than excludes a match.
+type ignoreRule struct {
+	regex  *regexp.Regexp
+	negate bool
+}
 
-		regexPattern := globToRegex(pattern)
-		matched, err := regexp.MatchString(regexPattern, dirName)
-		if err != nil {
-			fmt.Printf("Warning: Invalid regex pattern '%s': %v\n", pattern, err)
-			continue
-		}
-		if matched {
-			return true
+// compileIgnoreRules compiles raw gitignore-style pattern lines in order.
+// Later rules can override earlier ones, including "!pattern" negations
+// re-including a path an earlier pattern excluded.
+func compileIgnoreRules(patterns []string) []ignoreRule {
+	rules := make([]ignoreRule, 0, len(patterns))
+	for _, raw := range patterns {
+		rules = append(rules, compileIgnoreRule(raw))
+	}
+	return rules
+}
+
+func compileIgnoreRule(raw string) ignoreRule {
+	pattern := raw
+
+	negate := strings.HasPrefix(pattern, "!")
+	if negate {
+		pattern = strings.TrimPrefix(pattern, "!")
+	}
+
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	if !anchored && !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	return ignoreRule{regex: regexp.MustCompile(gitignoreToRegex(pattern)), negate: negate}
+}
+
+// gitignoreToRegex translates a single gitignore glob into an anchored
+// regex matched against a repo-relative directory path: "**/" matches
+// zero or more leading path segments, "**" matches any number of
+// segments, "*" and "?" stay within a single segment, "[...]" character
+// classes pass through unescaped (with a leading "!" negation rewritten
+// to Go regex's "^" since gitignore and Go disagree on that syntax), and
+// everything else is escaped.
+func gitignoreToRegex(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			sb.WriteString("[^/]*")
+			i++
+		case pattern[i] == '?':
+			sb.WriteString("[^/]")
+			i++
+		case pattern[i] == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end == -1 {
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+				i++
+				continue
+			}
+			class := pattern[i : i+end+1]
+			if strings.HasPrefix(class, "[!") {
+				class = "[^" + class[2:]
+			}
+			sb.WriteString(class)
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
 		}
 	}
-	return false
+
+	sb.WriteString("$")
+	return sb.String()
 }
 
-func globToRegex(glob string) string {
-	regex := strings.ReplaceAll(glob, "*", ".*")
-	regex = strings.ReplaceAll(regex, "?", ".")
-	return "^" + regex + "$"
+// shouldIgnoreDirectory reports whether relativePath (the directory's
+// path relative to the repo root) should be skipped, applying rules in
+// order so later patterns, including negations, override earlier ones.
+func shouldIgnoreDirectory(relativePath string, rules []ignoreRule) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.regex.MatchString(relativePath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
 }
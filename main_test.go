@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitignoreToRegexNegatedCharacterClass(t *testing.T) {
+	rule := compileIgnoreRule("[!a]bc")
+
+	tests := []struct {
+		path   string
+		ignore bool
+	}{
+		{"xbc", true},
+		{"abc", false},
+	}
+
+	for _, tt := range tests {
+		if got := rule.regex.MatchString(tt.path); got != tt.ignore {
+			t.Errorf("pattern [!a]bc vs %q: got %v, want %v", tt.path, got, tt.ignore)
+		}
+	}
+}
+
+func TestShouldIgnoreDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		want     bool
+	}{
+		{"simple match", []string{"node_modules"}, "node_modules", true},
+		{"unanchored matches any depth", []string{"build"}, "sub/build", true},
+		{"anchored only matches root", []string{"/build"}, "sub/build", false},
+		{"later negation re-includes", []string{"*.log", "!important.log"}, "important.log", false},
+		{"negation does not affect unrelated path", []string{"*.log", "!important.log"}, "debug.log", true},
+		{"double star matches nested", []string{"**/cache"}, "a/b/cache", true},
+		{"character class", []string{"[!a]bc"}, "xbc", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := compileIgnoreRules(tt.patterns)
+			if got := shouldIgnoreDirectory(tt.path, rules); got != tt.want {
+				t.Errorf("shouldIgnoreDirectory(%q, %v) = %v, want %v", tt.path, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOwnerRepo(t *testing.T) {
+	tests := []struct {
+		name      string
+		repoURL   string
+		wantOwner string
+		wantRepo  string
+		wantErr   bool
+	}{
+		{"github URL", "https://github.com/owner/repo", "owner", "repo", false},
+		{"github URL with .git suffix", "https://github.com/owner/repo.git", "owner", "repo", false},
+		{"github URL with trailing slash", "https://github.com/owner/repo/", "owner", "repo", false},
+		{"no path segments at all", "repo", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, repo, err := parseOwnerRepo(tt.repoURL)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseOwnerRepo(%q) = nil error, want error", tt.repoURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOwnerRepo(%q) returned unexpected error: %v", tt.repoURL, err)
+			}
+			if owner != tt.wantOwner || repo != tt.wantRepo {
+				t.Errorf("parseOwnerRepo(%q) = (%q, %q), want (%q, %q)", tt.repoURL, owner, repo, tt.wantOwner, tt.wantRepo)
+			}
+		})
+	}
+}
+
+// fakeProvider is a test double for Provider whose DiscoverDefaultBranch
+// behavior is controlled directly, without hitting a real hosting API.
+type fakeProvider struct {
+	defaultBranch string
+	err           error
+}
+
+func (p fakeProvider) ArchiveURL(repoURL, ref string) (string, error) { return "", nil }
+func (p fakeProvider) AuthHeaders(token string) map[string]string     { return nil }
+func (p fakeProvider) CloneCredentials(token string) (string, string) { return "", token }
+func (p fakeProvider) DiscoverDefaultBranch(ctx context.Context, repoURL, token string) (string, error) {
+	return p.defaultBranch, p.err
+}
+
+func TestResolveRefCandidates(t *testing.T) {
+	tests := []struct {
+		name        string
+		explicitRef string
+		provider    Provider
+		want        []string
+	}{
+		{"explicit ref wins outright", "v1.2.3", fakeProvider{defaultBranch: "main"}, []string{"v1.2.3"}},
+		{"falls back to provider's default branch", "", fakeProvider{defaultBranch: "develop"}, []string{"develop"}},
+		{"falls back to main/master when provider lookup fails", "", fakeProvider{err: errors.New("API unavailable")}, []string{"main", "master"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveRefCandidates(context.Background(), "https://github.com/owner/repo", tt.explicitRef, "", tt.provider)
+			if len(got) != len(tt.want) {
+				t.Fatalf("resolveRefCandidates(...) = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("resolveRefCandidates(...) = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBuildJobs(t *testing.T) {
+	t.Run("repo URLs with no manifest", func(t *testing.T) {
+		jobs, err := buildJobs([]string{"https://github.com/a/a", "https://github.com/b/b"}, "")
+		if err != nil {
+			t.Fatalf("buildJobs returned unexpected error: %v", err)
+		}
+		want := []RepoJob{{URL: "https://github.com/a/a"}, {URL: "https://github.com/b/b"}}
+		if len(jobs) != len(want) || jobs[0] != want[0] || jobs[1] != want[1] {
+			t.Errorf("buildJobs(...) = %+v, want %+v", jobs, want)
+		}
+	})
+
+	t.Run("manifest jobs appended after repo URL jobs", func(t *testing.T) {
+		manifestPath := filepath.Join(t.TempDir(), "manifest.txt")
+		manifest := "# comment\n\nhttps://github.com/c/c,v1,subdir\nhttps://github.com/d/d\n"
+		if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+			t.Fatalf("failed to write manifest: %v", err)
+		}
+
+		jobs, err := buildJobs([]string{"https://github.com/a/a"}, manifestPath)
+		if err != nil {
+			t.Fatalf("buildJobs returned unexpected error: %v", err)
+		}
+
+		want := []RepoJob{
+			{URL: "https://github.com/a/a"},
+			{URL: "https://github.com/c/c", Ref: "v1", OutputSubdir: "subdir"},
+			{URL: "https://github.com/d/d"},
+		}
+		if len(jobs) != len(want) {
+			t.Fatalf("buildJobs(...) = %+v, want %+v", jobs, want)
+		}
+		for i := range want {
+			if jobs[i] != want[i] {
+				t.Errorf("buildJobs(...)[%d] = %+v, want %+v", i, jobs[i], want[i])
+			}
+		}
+	})
+
+	t.Run("missing manifest file is an error", func(t *testing.T) {
+		if _, err := buildJobs(nil, filepath.Join(t.TempDir(), "does-not-exist.txt")); err == nil {
+			t.Error("buildJobs with missing manifest = nil error, want error")
+		}
+	})
+}
+
+func TestUnderAnySparsePath(t *testing.T) {
+	tests := []struct {
+		name         string
+		relativePath string
+		sparsePaths  []string
+		want         bool
+	}{
+		{"exact match", "src/pkg", []string{"src/pkg"}, true},
+		{"descendant of a sparse path", "src/pkg/internal", []string{"src/pkg"}, true},
+		{"ancestor of a sparse path", "src", []string{"src/pkg"}, true},
+		{"unrelated sibling", "docs", []string{"src/pkg"}, false},
+		{"prefix match without separator is not a match", "src/pkgextra", []string{"src/pkg"}, false},
+		{"matches one of several sparse paths", "docs", []string{"src/pkg", "docs"}, true},
+		{"tolerates leading/trailing slashes", "/src/pkg/", []string{"/src/pkg/"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := underAnySparsePath(tt.relativePath, tt.sparsePaths); got != tt.want {
+				t.Errorf("underAnySparsePath(%q, %v) = %v, want %v", tt.relativePath, tt.sparsePaths, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	destDir := filepath.Join(t.TempDir(), "out")
+
+	ok := []string{"src", "src/pkg", "a/b/c"}
+	for _, relativePath := range ok {
+		got, err := safeJoin(destDir, relativePath)
+		if err != nil {
+			t.Errorf("safeJoin(%q, %q) returned unexpected error: %v", destDir, relativePath, err)
+			continue
+		}
+		if !strings.HasPrefix(got, destDir) {
+			t.Errorf("safeJoin(%q, %q) = %q, want path under destDir", destDir, relativePath, got)
+		}
+	}
+
+	unsafe := []string{"../escape", "../../etc/passwd", "/etc/passwd", `C:\Windows`}
+	for _, relativePath := range unsafe {
+		if _, err := safeJoin(destDir, relativePath); err == nil {
+			t.Errorf("safeJoin(%q, %q) = nil error, want rejection", destDir, relativePath)
+		}
+	}
+}
+
+func TestArchiveRootDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{"common top-level directory", []string{"repo-main/", "repo-main/src/", "repo-main/README.md"}, "repo-main/"},
+		{"common prefix trims back to last separator", []string{"repo-main/src/a.go", "repo-main/src/b.go"}, "repo-main/src/"},
+		{"no common directory", []string{"a.go", "b.go"}, ""},
+		{"single entry with no separator", []string{"README.md"}, ""},
+		{"empty archive", []string{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make([]*zip.File, len(tt.names))
+			for i, name := range tt.names {
+				files[i] = &zip.File{FileHeader: zip.FileHeader{Name: name}}
+			}
+			if got := archiveRootDir(files); got != tt.want {
+				t.Errorf("archiveRootDir(%v) = %q, want %q", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeTestZip(t *testing.T, dirs []string) string {
+	t.Helper()
+
+	zipFile, err := os.CreateTemp("", "extract-test-*.zip")
+	if err != nil {
+		t.Fatalf("failed to create temp ZIP file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(zipFile.Name()) })
+
+	writer := zip.NewWriter(zipFile)
+	for _, dir := range dirs {
+		if _, err := writer.Create(dir); err != nil {
+			t.Fatalf("failed to add %q to ZIP: %v", dir, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to finalize ZIP: %v", err)
+	}
+	zipFile.Close()
+
+	return zipFile.Name()
+}
+
+func TestExtractZipDirectoriesOnlyMaxPathDepth(t *testing.T) {
+	zipPath := writeTestZip(t, []string{
+		"repo-main/",
+		"repo-main/top/",
+		"repo-main/top/nested/",
+	})
+
+	tests := []struct {
+		name         string
+		maxPathDepth int
+		wantDirs     []string
+	}{
+		{"negative means unlimited", -1, []string{"top", "top/nested"}},
+		{"zero means root only", 0, []string{}},
+		{"one allows a single level", 1, []string{"top"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			limits := ExtractLimits{MaxPathDepth: tt.maxPathDepth}
+			if err := extractZipDirectoriesOnly(zipPath, destDir, nil, limits); err != nil {
+				t.Fatalf("extractZipDirectoriesOnly returned error: %v", err)
+			}
+			for _, wantDir := range tt.wantDirs {
+				if _, err := os.Stat(filepath.Join(destDir, wantDir)); err != nil {
+					t.Errorf("expected %q to be extracted: %v", wantDir, err)
+				}
+			}
+			if len(tt.wantDirs) == 0 {
+				if _, err := os.Stat(filepath.Join(destDir, "top")); !os.IsNotExist(err) {
+					t.Errorf("expected %q to not be extracted, got err=%v", "top", err)
+				}
+			}
+		})
+	}
+}